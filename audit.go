@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is one line of the optional -audit log: enough to let a user
+// post-process a campaign (dedupe crashes across runs, plot throughput,
+// correlate crashing seeds with corpus files) without re-running anything.
+type auditEntry struct {
+	Time        time.Time `json:"time"`
+	Worker      int       `json:"worker"`
+	RawSHA1     string    `json:"raw_sha1"`
+	FixedSHA1   string    `json:"fixed_sha1,omitempty"`
+	Bytes       int       `json:"bytes"`
+	ExitStatus  int       `json:"exit_status"`
+	Signal      int       `json:"signal,omitempty"`
+	DurationSec float64   `json:"duration_secs"`
+	Crash       bool      `json:"crash"`
+	CrashFile   string    `json:"crash_file,omitempty"`
+	Extra       []string  `json:"extra,omitempty"`
+}
+
+// auditLogger streams auditEntry values to a file as newline-delimited
+// JSON, one per executed test. Writes happen on a background goroutine fed
+// by a buffered channel so a slow disk only stalls the fuzz loop once that
+// buffer is full.
+type auditLogger struct {
+	ch chan auditEntry
+	f  *os.File
+	wg sync.WaitGroup
+}
+
+func newAuditLogger(path string) (*auditLogger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	al := &auditLogger{ch: make(chan auditEntry, 256), f: f}
+	al.wg.Add(1)
+	go al.run()
+	return al, nil
+}
+
+func (al *auditLogger) run() {
+	defer al.wg.Done()
+	enc := json.NewEncoder(al.f)
+	for e := range al.ch {
+		if err := enc.Encode(e); err != nil {
+			l.Warnln(fmt.Sprintf("[WARNING] failed to write audit entry: %s", err))
+		}
+	}
+}
+
+// log enqueues an entry for writing. It never blocks the fuzz loop on disk
+// I/O directly, but will apply backpressure if the channel buffer fills.
+func (al *auditLogger) log(e auditEntry) {
+	al.ch <- e
+}
+
+func (al *auditLogger) close() {
+	close(al.ch)
+	al.wg.Wait()
+	al.f.Close()
+}
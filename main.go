@@ -7,31 +7,67 @@ import (
 	"flag"
 	"fmt"
 	"github.com/bnagy/francis"
+	"github.com/bnagy/terry/mutator"
+	"github.com/bnagy/terry/tlog"
+	"github.com/bnagy/terry/triage"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var l = tlog.New()
+
 const MAXLEN = 10 * 1024 * 1024 // 10 MB
 
 var (
-	flagFn      *string = flag.String("fn", ".cur_input", "filename to use")
-	flagDestDir *string = flag.String("dest", "", "directory to use to stage tests to disk")
-	flagSrcDir  *string = flag.String("src", "", "directory with test corpus")
-	flagServer  *string = flag.String("server", "", "remote radamsa server to use for tests")
-	flagFix     *string = flag.String("fix", "", "Unix socket to use to fix tests")
-	flagTimeout *int    = flag.Int("t", -1, "timeout in secs for app under test")
-	// flagWorkers  *int    = flag.Int("workers", 1, "Number of concurrent workers")
+	flagFn      *string        = flag.String("fn", ".cur_input", "filename to use")
+	flagDestDir *string        = flag.String("dest", "", "directory to use to stage tests to disk")
+	flagSrcDir  *string        = flag.String("src", "", "directory with test corpus")
+	flagServer  *string        = flag.String("server", "", "remote radamsa server to use for tests")
+	flagFix     *string        = flag.String("fix", "", "Unix socket to use to fix tests")
+	flagTimeout *int           = flag.Int("t", -1, "timeout in secs for app under test")
+	flagWorkers *int           = flag.Int("workers", 1, "Number of concurrent workers")
+	flagAudit   *string        = flag.String("audit", "", "path to a JSON audit log of every test and crash")
+	flagMutator *string        = flag.String("mutator", "radamsa", "mutation backend to use: radamsa|havoc|replay")
+	flagRepro   *int           = flag.Int("repro", 1, "re-run a crash this many times to confirm it's reproducible before saving")
+	flagMinTime *time.Duration = flag.Duration("min-time", 0, "time budget for delta-debug minimization of a crash (0 disables)")
+)
+
+// bucketMu guards bucketCounts, which gives every crash saved into a
+// bucket its own ordinal, rather than every worker racing to pick one.
+var (
+	bucketMu     sync.Mutex
+	bucketCounts = map[string]int{}
 )
 
+func nextBucketIndex(bucket string) int {
+	bucketMu.Lock()
+	defer bucketMu.Unlock()
+	bucketCounts[bucket]++
+	return bucketCounts[bucket]
+}
+
+// audit is nil unless -audit is set, in which case every worker logs to it.
+var audit *auditLogger
+
+// count is a shared counter, bumped by every worker as each test is staged
+// (just before it runs), and read by the 30-second progress printer.
+var count int64
+
+// shuttingDown is flipped to 1 once SIGINT is caught. Workers poll it
+// between tests so that an in-flight test is always allowed to finish.
+var shuttingDown int32
+
 func sleepyConnect(dest string) (s net.Conn, err error) {
 	zzz := 1 * time.Millisecond
 	for {
@@ -80,37 +116,36 @@ func readNetString(r *bufio.Reader) ([]byte, error) {
 	return data, nil
 }
 
-func stageTest(raw []byte) {
-	err := ioutil.WriteFile(path.Join(*flagDestDir, *flagFn), raw, 0600)
-	if err != nil {
-		log.Fatalf("[SAD] failed to create test file: %s", err)
-	}
-}
-
-func getTest() ([]byte, []byte) {
-
-	conn, err := net.Dial("tcp", "127.0.0.1:4141")
-	if err != nil {
-		log.Fatalf("[SAD] Unable to connect to radamsa server: %s", err)
-	}
-
-	hsh := sha1.New()
-	tee := io.TeeReader(conn, hsh)
-	raw, err := ioutil.ReadAll(tee)
-
+func stageTest(fn string, raw []byte) {
+	l.Debugln("stage", "writing", len(raw), "bytes to", fn)
+	err := ioutil.WriteFile(fn, raw, 0600)
 	if err != nil {
-		log.Fatalf("[SAD] Error reading from server: %s", err)
+		l.Fatalln("[SAD] failed to create test file:", err)
 	}
-	return raw, hsh.Sum(nil)
 }
 
 func saveTest(fn string, raw []byte) {
+	l.Debugln("crash", "saving crashfile", fn)
 	err := ioutil.WriteFile(path.Join(*flagDestDir, "crashes", fn), raw, 0600)
 	if err != nil {
-		log.Printf("[SUPER SAD] failed to write crashfile!: %s\n", err)
+		l.Warnln("[SUPER SAD] failed to write crashfile!:", err)
 		hex.Dump(raw)
-		log.Fatalf("[SUPER SAD] (that hexdump was the last test)\n")
+		l.Fatalln("[SUPER SAD] (that hexdump was the last test)")
+	}
+}
+
+// saveCrash writes raw into crashes/<bucket>/ under its own ordinal within
+// that bucket, and returns the path (relative to the crashes dir) it was
+// saved at, for the audit log.
+func saveCrash(bucket string, raw []byte) string {
+	bucketDir := path.Join(*flagDestDir, "crashes", bucket)
+	if err := os.MkdirAll(bucketDir, 0700); err != nil {
+		l.Fatalln(fmt.Sprintf("[SAD] failed to create crash bucket dir: %s", err))
 	}
+	sum := sha1.Sum(raw)
+	fn := path.Join(bucket, fmt.Sprintf("%d_%s.raw", nextBucketIndex(bucket), hex.EncodeToString(sum[:])))
+	saveTest(fn, raw)
+	return fn
 }
 
 func fixTest(t []byte, conn net.Conn, rd *bufio.Reader) ([]byte, error) {
@@ -119,12 +154,167 @@ func fixTest(t []byte, conn net.Conn, rd *bufio.Reader) ([]byte, error) {
 	conn.Write([]byte(s))
 	fixed, err := readNetString(rd)
 	if err != nil {
+		l.Debugln("fix", "fix socket read failed:", err)
 		return []byte{}, err
 	}
 
+	l.Debugln("fix", "fixed test to", len(fixed), "bytes")
 	return fixed, nil
 }
 
+// worker owns everything a single fuzzing goroutine needs that can't be
+// safely shared: its own staging directory (so concurrent @@ substitutions
+// never collide), its own Mutator and, optionally, its own connection to
+// the fix socket.
+type worker struct {
+	id        int
+	destDir   string
+	testPath  string
+	mut       mutator.Mutator
+	fixConn   net.Conn
+	fixReader *bufio.Reader
+}
+
+// newMutator builds the Mutator backend selected by -mutator for worker id.
+// corpus is the pre-loaded -src directory, shared read-only across workers
+// (only used by the havoc backend); srvAddr is only used by radamsa.
+func newMutator(id int, srvAddr string, corpus [][]byte) (mutator.Mutator, error) {
+	switch *flagMutator {
+	case "radamsa":
+		return mutator.NewRadamsa(srvAddr), nil
+	case "havoc":
+		return mutator.NewHavoc(corpus, time.Now().UnixNano()+int64(id)), nil
+	case "replay":
+		return mutator.NewReplay(*flagSrcDir, id, *flagWorkers)
+	default:
+		return nil, fmt.Errorf("unknown -mutator %q", *flagMutator)
+	}
+}
+
+func newWorker(id int, srvAddr string, corpus [][]byte) (*worker, error) {
+
+	destDir := path.Join(*flagDestDir, fmt.Sprintf("worker-%d", id))
+	if err := os.MkdirAll(destDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create worker dir: %s", err)
+	}
+
+	mut, err := newMutator(id, srvAddr, corpus)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &worker{
+		id:       id,
+		destDir:  destDir,
+		testPath: path.Join(destDir, *flagFn),
+		mut:      mut,
+	}
+
+	if *flagFix != "" {
+		fixConn, err := net.Dial("unix", *flagFix)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dial fix socket: %s", err)
+		}
+		w.fixConn = fixConn
+		w.fixReader = bufio.NewReader(fixConn)
+	}
+
+	return w, nil
+}
+
+func (w *worker) close() {
+	w.mut.Close()
+	if w.fixConn != nil {
+		w.fixConn.Close()
+	}
+}
+
+// run is the per-worker fuzz loop. It exits once shuttingDown is set,
+// always finishing whatever test is currently in flight first.
+func (w *worker) run(wg *sync.WaitGroup, testCmd []string, eng *francis.Engine) {
+	defer wg.Done()
+	defer w.close()
+
+	cmd := make([]string, len(testCmd))
+	copy(cmd, testCmd)
+	for i, elem := range cmd {
+		if elem == "@@" {
+			cmd[i] = w.testPath
+		}
+	}
+
+	for atomic.LoadInt32(&shuttingDown) == 0 {
+
+		test, sha, err := w.mut.Next()
+		if err == mutator.ErrExhausted {
+			l.Infoln(fmt.Sprintf("[CALM] worker %d: mutator exhausted, stopping", w.id))
+			return
+		}
+		if err != nil {
+			l.Fatalln(fmt.Sprintf("[SAD] worker %d: error reading from mutator: %s", w.id, err))
+		}
+		if len(test) > MAXLEN {
+			continue
+		}
+
+		fixedSha := ""
+		if w.fixConn != nil {
+			test, err = fixTest(test, w.fixConn, w.fixReader)
+			if err != nil {
+				l.Fatalln(fmt.Sprintf("[SAD] worker %d: failed to fix test: %s", w.id, err))
+			}
+			fixedSum := sha1.Sum(test)
+			fixedSha = hex.EncodeToString(fixedSum[:])
+		}
+		stageTest(w.testPath, test)
+
+		atomic.AddInt64(&count, 1)
+
+		start := time.Now()
+		l.Debugln("exec", fmt.Sprintf("worker %d: running test", w.id))
+		ci, err := eng.Run(cmd)
+		entry := auditEntry{
+			Time:        start,
+			Worker:      w.id,
+			RawSHA1:     hex.EncodeToString(sha[:]),
+			FixedSHA1:   fixedSha,
+			Bytes:       len(test),
+			ExitStatus:  ci.Status,
+			Signal:      ci.Signal,
+			DurationSec: time.Since(start).Seconds(),
+		}
+		if err == nil {
+			// this is backasswards for this application. For the triage tool
+			// err meant there was no crash.
+			l.Infoln(fmt.Sprintf("[HAPPY] Crash! - %s", ci.Extra[0]))
+
+			runner := func(in []byte) (bool, error) {
+				stageTest(w.testPath, in)
+				_, err := eng.Run(cmd)
+				return err == nil, nil
+			}
+
+			hits, _ := triage.Reproduce(test, *flagRepro, runner)
+			if hits == 0 {
+				// Flaky crashes (races, uninitialized memory, ASLR-sensitive
+				// overflows) are common and still worth keeping - just off to
+				// one side, since we couldn't confirm them against -repro.
+				l.Infoln(fmt.Sprintf("[CALM] worker %d: crash did not reproduce, saving as unconfirmed", w.id))
+				entry.CrashFile = saveCrash("unconfirmed", test)
+			} else {
+				minimized := triage.Minimize(test, *flagMinTime, runner)
+				stageTest(w.testPath, minimized)
+				entry.CrashFile = saveCrash(triage.Bucket(ci.Extra), minimized)
+			}
+			entry.Crash = true
+			entry.Extra = ci.Extra
+		}
+		if audit != nil {
+			audit.log(entry)
+		}
+	}
+}
+
 func main() {
 
 	flag.Usage = func() {
@@ -141,141 +331,173 @@ func main() {
 
 	flag.Parse()
 
-	log.Printf("%s - performing startup checks...\n", os.Args[0])
+	l.Infoln(fmt.Sprintf("%s - performing startup checks...", os.Args[0]))
 
 	testCmd := flag.Args()
 	if len(testCmd) < 2 {
-		log.Fatalf("[SAD] Minimum target command is: /path/to/target @@\n")
+		l.Fatalln("[SAD] Minimum target command is: /path/to/target @@")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if len(*flagServer) != 0 && len(*flagSrcDir) != 0 {
-		log.Fatalf("[SAD] -src and -server cannot be used together.\n")
+	switch *flagMutator {
+	case "radamsa", "havoc", "replay":
+	default:
+		l.Fatalln(fmt.Sprintf("[SAD] unknown -mutator %q (want radamsa, havoc or replay)", *flagMutator))
+	}
+	if *flagMutator != "radamsa" && *flagServer != "" {
+		l.Fatalln("[SAD] -server only applies to -mutator radamsa")
 	}
-	if len(*flagServer) == 0 && len(*flagSrcDir) == 0 {
-		log.Fatalf("[SAD] need a corpus directory (-src) or a radamsa server address (-server)\n")
+	if *flagMutator == "radamsa" {
+		if len(*flagServer) != 0 && len(*flagSrcDir) != 0 {
+			l.Fatalln("[SAD] -src and -server cannot be used together.")
+		}
+		if len(*flagServer) == 0 && len(*flagSrcDir) == 0 {
+			l.Fatalln("[SAD] need a corpus directory (-src) or a radamsa server address (-server)")
+		}
+	} else if *flagSrcDir == "" {
+		l.Fatalln("[SAD] -mutator havoc/replay need a -src directory to read from")
+	}
+	if *flagWorkers < 1 {
+		l.Fatalln("[SAD] -workers must be >= 1")
 	}
 
 	srvAddr := ""
+	var radamsa *exec.Cmd
+	var corpus [][]byte
 
-	if len(*flagSrcDir) > 0 {
-		// Sanity checks on the source directory
-		fi, err := os.Stat(*flagSrcDir)
-		if err != nil {
-			log.Fatalf("[SAD] unable to open -src dir: %s", err)
-		}
-		if !fi.IsDir() {
-			log.Fatalf("[SAD] -src is not a directory.")
-		}
-		if files, _ := filepath.Glob(path.Join(*flagSrcDir, "*")); len(files) < 1 {
-			log.Printf("[WARNING] no files in source directory!\n")
+	if *flagMutator == "radamsa" {
+		if len(*flagSrcDir) > 0 {
+			// Sanity checks on the source directory
+			fi, err := os.Stat(*flagSrcDir)
+			if err != nil {
+				l.Fatalln(fmt.Sprintf("[SAD] unable to open -src dir: %s", err))
+			}
+			if !fi.IsDir() {
+				l.Fatalln("[SAD] -src is not a directory.")
+			}
+			if files, _ := filepath.Glob(path.Join(*flagSrcDir, "*")); len(files) < 1 {
+				l.Warnln("[WARNING] no files in source directory!")
+			}
+			l.Infoln("[HAPPY] source dir looks ok...")
+
+			// Start and test the local radamsa server
+			radamsa = exec.Command("radamsa", "-n", "inf", "-o", ":4141", "-r", *flagSrcDir)
+			err = radamsa.Start()
+			if err != nil {
+				l.Fatalln(fmt.Sprintf("[SAD] Unable to launch radamsa server: %s", err))
+			}
+			srvAddr = "127.0.0.1:4141"
+
+		} else {
+			srvAddr = *flagServer
 		}
-		log.Printf("[HAPPY] source dir looks ok...\n")
 
-		// Start and test the local radamsa server
-		radamsa := exec.Command("radamsa", "-n", "inf", "-o", ":4141", "-r", *flagSrcDir)
-		err = radamsa.Start()
+		// reap the radamsa subprocess (if we started one) on the way out, after
+		// giving it a chance to die from the signal below.
+		defer func() {
+			if radamsa == nil {
+				return
+			}
+			radamsa.Process.Kill()
+			radamsa.Wait()
+		}()
+
+		conn, err := sleepyConnect(srvAddr)
 		if err != nil {
-			log.Fatalf("[SAD] Unable to launch radamsa server: %s", err)
+			l.Fatalln(fmt.Sprintf("[SAD] Unable to connect to radamsa server: %s", err))
 		}
-		defer radamsa.Process.Kill()
-		srvAddr = "127.0.0.1:4141"
-
-	} else {
-		srvAddr = *flagServer
+		_, err = ioutil.ReadAll(conn)
+		if err != nil {
+			l.Fatalln(fmt.Sprintf("[SAD] Error reading from server: %s", err))
+		}
+		conn.Close()
+		l.Infoln("[HAPPY] radamsa server is running...")
 	}
 
-	conn, err := sleepyConnect(srvAddr)
-	if err != nil {
-		log.Fatalf("[SAD] Unable to connect to radamsa server: %s", err)
-	}
-	_, err = ioutil.ReadAll(conn)
-	if err != nil {
-		log.Fatalf("[SAD] Error reading from server: %s", err)
+	if *flagMutator == "havoc" {
+		var err error
+		corpus, err = mutator.LoadCorpus(*flagSrcDir)
+		if err != nil {
+			l.Fatalln(fmt.Sprintf("[SAD] unable to read -src corpus: %s", err))
+		}
+		if len(corpus) == 0 {
+			l.Fatalln("[SAD] -src corpus is empty, havoc mutator needs at least one file")
+		}
+		l.Infoln(fmt.Sprintf("[HAPPY] loaded %d corpus files for havoc mutation...", len(corpus)))
 	}
-	log.Printf("[HAPPY] radamsa server is running...\n")
 
 	// Sanity checks on the dest dir
 	fi, err := os.Stat(*flagDestDir)
 	if err == nil && !fi.IsDir() {
-		log.Fatalf("[SAD] -dest is not a directory.")
+		l.Fatalln("[SAD] -dest is not a directory.")
 	}
 	if err != nil {
 		// Make the crashdir at the same time
 		err = os.MkdirAll(path.Join(*flagDestDir, "crashes"), 0700)
 		if err != nil {
-			log.Fatalf("[SAD] failed to create -dest: %s", err)
+			l.Fatalln(fmt.Sprintf("[SAD] failed to create -dest: %s", err))
 		}
 	}
-	err = ioutil.WriteFile(path.Join(*flagDestDir, *flagFn), []byte("test"), 0600)
-	if err != nil {
-		log.Fatalf("[SAD] failed to create test file: %s", err)
-	}
-	log.Printf("[HAPPY] dest dir looks ok...\n")
+	l.Infoln("[HAPPY] dest dir looks ok...")
 
 	// make sure there's at least one substitute marker
 	sub := 0
-	for i, elem := range testCmd {
+	for _, elem := range testCmd {
 		if elem == "@@" {
-			testCmd[i] = path.Join(*flagDestDir, *flagFn)
 			sub++
 		}
 	}
 	if sub == 0 {
-		log.Fatalf("[SAD] No substitute markers ( @@ ) in supplied command")
+		l.Fatalln("[SAD] No substitute markers ( @@ ) in supplied command")
 	}
-	log.Printf("[CALM] Will be fuzzing: %s\n", strings.Join(testCmd, " "))
+	l.Infoln(fmt.Sprintf("[CALM] Will be fuzzing: %s", strings.Join(testCmd, " ")))
 
-	// test the fix sock, if given
-	var fixReader *bufio.Reader
-	var fixConn net.Conn
-	if *flagFix != "" {
-		fixConn, err = net.Dial("unix", *flagFix)
+	if *flagAudit != "" {
+		audit, err = newAuditLogger(*flagAudit)
 		if err != nil {
-			log.Fatalf("[SAD] Unable to dial fix socket: %s", err)
+			l.Fatalln(fmt.Sprintf("[SAD] unable to open -audit log: %s", err))
 		}
-		fixReader = bufio.NewReader(fixConn)
+		defer audit.close()
+		l.Infoln(fmt.Sprintf("[HAPPY] audit log open at %s", *flagAudit))
 	}
 
-	francis := &francis.Engine{*flagTimeout}
-	log.Printf("[HAPPY] everything looks good. Let's go!\n")
+	workers := make([]*worker, *flagWorkers)
+	for i := range workers {
+		w, err := newWorker(i, srvAddr, corpus)
+		if err != nil {
+			l.Fatalln(fmt.Sprintf("[SAD] failed to start worker %d: %s", i, err))
+		}
+		workers[i] = w
+	}
+
+	eng := &francis.Engine{Timeout: *flagTimeout}
+	l.Infoln("[HAPPY] everything looks good. Let's go!")
+
+	// Catch SIGINT and let in-flight tests finish instead of dying mid-run.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		l.Infoln("[CALM] caught interrupt, finishing in-flight tests...")
+		atomic.StoreInt32(&shuttingDown, 1)
+	}()
 
 	mark := time.Now()
-	count := 0
-	timer := time.Tick(30 * time.Second)
+	ticker := time.Tick(30 * time.Second)
 	go func() {
 		for {
-			<-timer
+			<-ticker
 			elapsed := (time.Since(mark) / time.Second) * time.Second // truncate to 1s resolution
-			fmt.Printf("\r[CALM] %d tests in %s (%.2f / s) %.20s", count, elapsed, float64(count)/float64(elapsed/time.Second), " ")
+			n := atomic.LoadInt64(&count)
+			fmt.Printf("\r[CALM] %d tests in %s (%.2f / s) %.20s", n, elapsed, float64(n)/float64(elapsed/time.Second), " ")
 		}
 	}()
 
-	for {
-
-		test, sha := getTest()
-		if len(test) > MAXLEN {
-			continue
-		}
-		if fixConn != nil {
-			test, err = fixTest(test, fixConn, fixReader)
-			if err != nil {
-				log.Fatalf("[SAD] failed to fix test: %s", err)
-			}
-		}
-		stageTest(test)
-
-		count++
-
-		ci, err := francis.Run(testCmd)
-		if err == nil {
-			// this is backasswards for this application. For the triage tool
-			// err meant there was no crash.
-			log.Printf("[HAPPY] Crash! - %s", ci.Extra[0])
-			saveTest(fmt.Sprintf("%s.raw", hex.EncodeToString(sha)), test)
-		}
-
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go w.run(&wg, testCmd, eng)
 	}
-
+	wg.Wait()
 }
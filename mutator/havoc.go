@@ -0,0 +1,102 @@
+package mutator
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+)
+
+// LoadCorpus reads every regular file directly under dir into memory. The
+// result is read-only test material shared across all Havoc instances, so
+// it is loaded once rather than once per worker.
+func LoadCorpus(dir string) ([][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	corpus := make([][]byte, 0, len(files))
+	for _, fn := range files {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			continue
+		}
+		corpus = append(corpus, b)
+	}
+	return corpus, nil
+}
+
+// Havoc is a small AFL-style byte mutator: bitflips, arithmetic
+// increment/decrement, and block splicing between two corpus entries. It
+// needs no external radamsa process, at the cost of being a much cruder
+// mutation strategy.
+type Havoc struct {
+	corpus [][]byte
+	rng    *rand.Rand
+}
+
+// NewHavoc builds a Havoc mutator over corpus, seeded from seed so that
+// concurrent workers (each with a distinct seed) don't all replay the same
+// mutation sequence.
+func NewHavoc(corpus [][]byte, seed int64) *Havoc {
+	return &Havoc{corpus: corpus, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (h *Havoc) Next() (raw []byte, sha [20]byte, err error) {
+	src := h.corpus[h.rng.Intn(len(h.corpus))]
+	out := make([]byte, len(src))
+	copy(out, src)
+
+	switch h.rng.Intn(3) {
+	case 0:
+		out = h.bitflip(out)
+	case 1:
+		out = h.arith(out)
+	default:
+		out = h.splice(out)
+	}
+
+	return out, sum(out), nil
+}
+
+func (h *Havoc) bitflip(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	i := h.rng.Intn(len(b))
+	b[i] ^= 1 << uint(h.rng.Intn(8))
+	return b
+}
+
+func (h *Havoc) arith(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	i := h.rng.Intn(len(b))
+	delta := byte(h.rng.Intn(35) - 17) // roughly AFL's +/-16 range
+	b[i] += delta
+	return b
+}
+
+// splice replaces a random block of b with a same-sized block taken from
+// another random corpus entry.
+func (h *Havoc) splice(b []byte) []byte {
+	if len(b) == 0 || len(h.corpus) < 2 {
+		return b
+	}
+	donor := h.corpus[h.rng.Intn(len(h.corpus))]
+	if len(donor) == 0 {
+		return b
+	}
+	n := 1 + h.rng.Intn(len(b))
+	if n > len(donor) {
+		n = len(donor)
+	}
+	dst := h.rng.Intn(len(b) - n + 1)
+	src := h.rng.Intn(len(donor) - n + 1)
+	copy(b[dst:dst+n], donor[src:src+n])
+	return b
+}
+
+func (h *Havoc) Close() error {
+	return nil
+}
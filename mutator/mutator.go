@@ -0,0 +1,26 @@
+// Package mutator abstracts the source of mutated test cases. terry
+// originally talked to a local radamsa TCP server exclusively; this
+// package lets that be swapped for an in-process havoc mutator or a
+// replay of previously-saved crash inputs, so the tool is useful even
+// where the radamsa binary isn't available.
+package mutator
+
+import (
+	"crypto/sha1"
+	"errors"
+)
+
+// ErrExhausted is returned by Next once a Mutator has no more tests to
+// offer (currently only the replay mutator can do this).
+var ErrExhausted = errors.New("mutator: exhausted")
+
+// Mutator produces a stream of test cases to feed to the target.
+type Mutator interface {
+	// Next returns one test case and the sha1 of its raw bytes.
+	Next() (raw []byte, sha [20]byte, err error)
+	Close() error
+}
+
+func sum(raw []byte) [20]byte {
+	return sha1.Sum(raw)
+}
@@ -0,0 +1,54 @@
+package mutator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/bnagy/terry/tlog"
+)
+
+var l = tlog.New()
+
+// Radamsa talks to a radamsa TCP server (`radamsa -o :port ...`). The
+// server hands out exactly one mutated blob per TCP connection and then
+// closes it - there's no persistent, multi-test framing to read off a
+// long-lived socket, so Next dials fresh each time it's called. This
+// matches the startup probe in main.go (sleepyConnect -> ReadAll ->
+// Close), which is the only thing this repo ever confirmed about the
+// wire protocol.
+//
+// This is a deliberate deviation from chunk0-1's original ask for one
+// persistent radamsa connection per worker: a persistent connection can't
+// work against this protocol, since the server only ever gives out a
+// single blob before it closes the socket.
+type Radamsa struct {
+	addr string
+}
+
+// NewRadamsa builds a Radamsa backend that dials addr for every test.
+func NewRadamsa(addr string) *Radamsa {
+	return &Radamsa{addr: addr}
+}
+
+func (r *Radamsa) Next() (raw []byte, sha [20]byte, err error) {
+	conn, err := net.Dial("tcp", r.addr)
+	if err != nil {
+		l.Debugln("net", fmt.Sprintf("dial %s failed: %s", r.addr, err))
+		return nil, sha, err
+	}
+	defer conn.Close()
+
+	raw, err = ioutil.ReadAll(conn)
+	if err != nil {
+		l.Debugln("net", "read from radamsa failed:", err)
+		return nil, sha, err
+	}
+
+	l.Debugln("net", fmt.Sprintf("got %d bytes from radamsa", len(raw)))
+	return raw, sum(raw), nil
+}
+
+func (r *Radamsa) Close() error {
+	return nil
+}
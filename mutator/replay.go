@@ -0,0 +1,49 @@
+package mutator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// Replay feeds back a fixed directory of previously-saved crash inputs,
+// for regression runs against a fixed target. Once every file has been
+// returned, Next reports ErrExhausted.
+type Replay struct {
+	files []string
+	pos   int
+}
+
+// NewReplay lists dir (sorted, for reproducible ordering) and splits the
+// result nWorkers ways so that concurrent workers don't duplicate work:
+// worker workerID gets files[workerID], files[workerID+nWorkers], ...
+func NewReplay(dir string, workerID, nWorkers int) (*Replay, error) {
+	all, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(all)
+
+	var mine []string
+	for i := workerID; i < len(all); i += nWorkers {
+		mine = append(mine, all[i])
+	}
+
+	return &Replay{files: mine}, nil
+}
+
+func (r *Replay) Next() (raw []byte, sha [20]byte, err error) {
+	if r.pos >= len(r.files) {
+		return nil, sha, ErrExhausted
+	}
+	raw, err = ioutil.ReadFile(r.files[r.pos])
+	r.pos++
+	if err != nil {
+		return nil, sha, err
+	}
+	return raw, sum(raw), nil
+}
+
+func (r *Replay) Close() error {
+	return nil
+}
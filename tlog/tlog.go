@@ -0,0 +1,76 @@
+// Package tlog is a tiny topic-scoped tracing shim, used across terry
+// instead of ad-hoc log.Printf calls. Debug output is gated per-topic by
+// the TERRY_TRACE environment variable, e.g.
+//
+//	TERRY_TRACE=net,fix,exec,stage,crash terry -src ./corpus ...
+//
+// or TERRY_TRACE=all to enable everything. This lets an operator diagnose
+// a stuck worker, a malformed radamsa netstring or a fix-socket protocol
+// error without recompiling.
+package tlog
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	topics = map[string]bool{}
+	allOn  bool
+)
+
+func init() {
+	for _, t := range strings.Split(os.Getenv("TERRY_TRACE"), ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if t == "all" {
+			allOn = true
+		}
+		topics[t] = true
+	}
+}
+
+// Logger writes the fuzzer's operational log. It's cheap to construct and
+// safe for concurrent use, since all state it reads (the topic table) is
+// fixed at package init.
+type Logger struct{}
+
+// New returns a Logger. There is nothing to configure per-instance, tracing
+// is controlled entirely by TERRY_TRACE.
+func New() *Logger {
+	return &Logger{}
+}
+
+// Infoln logs a normal operational message, roughly what used to be a
+// "[HAPPY]"/"[CALM]" log.Printf.
+func (l *Logger) Infoln(v ...interface{}) {
+	log.Println(v...)
+}
+
+// Warnln logs a non-fatal problem, roughly what used to be a "[SAD]"
+// log.Printf that didn't call os.Exit.
+func (l *Logger) Warnln(v ...interface{}) {
+	log.Println(v...)
+}
+
+// Fatalln logs an unrecoverable problem and exits, same contract as
+// log.Fatalln.
+func (l *Logger) Fatalln(v ...interface{}) {
+	log.Fatalln(v...)
+}
+
+// Debugln logs v under the given topic, but only if that topic (or "all")
+// was enabled via TERRY_TRACE. The topic check is a single cheap map
+// lookup so Debugln calls are safe to leave in hot paths.
+func (l *Logger) Debugln(topic string, v ...interface{}) {
+	if !allOn && !topics[topic] {
+		return
+	}
+	args := make([]interface{}, 0, len(v)+1)
+	args = append(args, "["+topic+"]")
+	args = append(args, v...)
+	log.Println(args...)
+}
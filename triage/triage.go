@@ -0,0 +1,79 @@
+// Package triage turns a raw crash from the fuzz loop into something
+// worth keeping: confirmed reproducible, minimized, and bucketed with its
+// duplicates, rather than dumped as one more file in a flat crashes dir.
+// The pieces here take a Runner rather than talking to francis directly,
+// so they can be exercised without a real target binary.
+package triage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+)
+
+// Runner re-executes the target against input and reports whether that
+// run crashed. It's the only thing Reproduce and Minimize depend on.
+type Runner func(input []byte) (crashed bool, err error)
+
+// Bucket derives a short, stable identifier for a crash from the signal
+// that identified it (e.g. francis's ci.Extra). Crashes with the same
+// Extra land in the same bucket.
+func Bucket(extra []string) string {
+	h := sha1.New()
+	for _, e := range extra {
+		h.Write([]byte(e))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Reproduce re-runs input up to n times and returns how many of those
+// runs crashed. A flaky crash (hits < n) is still reported, so callers can
+// decide their own threshold for "reproducible enough to keep".
+func Reproduce(input []byte, n int, run Runner) (hits int, err error) {
+	for i := 0; i < n; i++ {
+		crashed, err := run(input)
+		if err != nil {
+			return hits, err
+		}
+		if crashed {
+			hits++
+		}
+	}
+	return hits, nil
+}
+
+// Minimize performs a simple delta-debugging reduction: repeatedly try
+// halving the input (first the front half, then the back half) and keep
+// whichever half still reproduces the crash. It stops shrinking once
+// neither half crashes, or once budget elapses, and always returns
+// something that crashed at least once (the original input at worst).
+func Minimize(input []byte, budget time.Duration, run Runner) []byte {
+	if budget <= 0 {
+		return input
+	}
+
+	deadline := time.Now().Add(budget)
+	best := input
+
+	for len(best) > 1 && time.Now().Before(deadline) {
+		mid := len(best) / 2
+		front, back := best[:mid], best[mid:]
+
+		shrunk := false
+		for _, half := range [][]byte{front, back} {
+			if len(half) == 0 {
+				continue
+			}
+			if crashed, err := run(half); err == nil && crashed {
+				best = half
+				shrunk = true
+				break
+			}
+		}
+		if !shrunk {
+			break
+		}
+	}
+
+	return best
+}
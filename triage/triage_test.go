@@ -0,0 +1,139 @@
+package triage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReproduce(t *testing.T) {
+	cases := []struct {
+		name     string
+		n        int
+		run      Runner
+		wantHits int
+		wantErr  bool
+	}{
+		{
+			name:     "always crashes",
+			n:        5,
+			run:      func(in []byte) (bool, error) { return true, nil },
+			wantHits: 5,
+		},
+		{
+			name:     "never crashes",
+			n:        5,
+			run:      func(in []byte) (bool, error) { return false, nil },
+			wantHits: 0,
+		},
+		{
+			name: "flaky, crashes every other run",
+			n:    4,
+			run: func() Runner {
+				i := 0
+				return func(in []byte) (bool, error) {
+					i++
+					return i%2 == 0, nil
+				}
+			}(),
+			wantHits: 2,
+		},
+		{
+			name:    "run returns an error on the first call",
+			n:       3,
+			run:     func(in []byte) (bool, error) { return false, errors.New("boom") },
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hits, err := Reproduce([]byte("input"), c.n, c.run)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Reproduce() err = %v, wantErr %v", err, c.wantErr)
+			}
+			if hits != c.wantHits {
+				t.Fatalf("Reproduce() hits = %d, want %d", hits, c.wantHits)
+			}
+		})
+	}
+}
+
+func TestBucket(t *testing.T) {
+	t.Run("identical Extra hashes the same", func(t *testing.T) {
+		a := Bucket([]string{"SIGSEGV", "pc=0x1234"})
+		b := Bucket([]string{"SIGSEGV", "pc=0x1234"})
+		if a != b {
+			t.Fatalf("Bucket() = %q and %q for identical Extra, want equal", a, b)
+		}
+	})
+
+	t.Run("different Extra hashes differently", func(t *testing.T) {
+		a := Bucket([]string{"SIGSEGV", "pc=0x1234"})
+		b := Bucket([]string{"SIGABRT", "pc=0x5678"})
+		if a == b {
+			t.Fatalf("Bucket() = %q for both, want different buckets for different Extra", a)
+		}
+	})
+
+	t.Run("empty Extra is handled sanely", func(t *testing.T) {
+		got := Bucket(nil)
+		if got == "" {
+			t.Fatalf("Bucket(nil) = %q, want a non-empty, deterministic bucket", got)
+		}
+		if want := Bucket([]string{}); got != want {
+			t.Fatalf("Bucket(nil) = %q, Bucket([]string{}) = %q, want equal", got, want)
+		}
+	})
+}
+
+// crashesOnMarker is a Runner that "crashes" iff its input still contains
+// the magic marker byte, standing in for a real target.
+func crashesOnMarker(marker byte) Runner {
+	return func(in []byte) (bool, error) {
+		return bytes.IndexByte(in, marker) >= 0, nil
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	t.Run("zero budget returns input unchanged", func(t *testing.T) {
+		input := []byte("the quick brown fox")
+		got := Minimize(input, 0, crashesOnMarker('q'))
+		if !bytes.Equal(got, input) {
+			t.Fatalf("Minimize() = %q, want input unchanged %q", got, input)
+		}
+	})
+
+	t.Run("shrinks to the smallest half still containing the marker", func(t *testing.T) {
+		input := append(bytes.Repeat([]byte{'a'}, 63), 'Z')
+		got := Minimize(input, time.Second, crashesOnMarker('Z'))
+
+		if bytes.IndexByte(got, 'Z') < 0 {
+			t.Fatalf("Minimize() = %q, lost the crashing marker", got)
+		}
+		if len(got) >= len(input) {
+			t.Fatalf("Minimize() = %q (len %d), expected it to shrink from len %d", got, len(got), len(input))
+		}
+		if want := []byte("Z"); !bytes.Equal(got, want) {
+			t.Fatalf("Minimize() = %q, want the smallest crashing half %q", got, want)
+		}
+	})
+
+	t.Run("stops when no half reproduces the crash", func(t *testing.T) {
+		input := []byte("nothing to see here")
+		run := func(in []byte) (bool, error) { return false, nil }
+		got := Minimize(input, time.Second, run)
+		if !bytes.Equal(got, input) {
+			t.Fatalf("Minimize() = %q, want original input back when nothing reproduces", got)
+		}
+	})
+
+	t.Run("a tiny budget still returns something that reproduces", func(t *testing.T) {
+		input := append(bytes.Repeat([]byte{'a'}, 1024), 'Z')
+		got := Minimize(input, time.Nanosecond, crashesOnMarker('Z'))
+		if bytes.IndexByte(got, 'Z') < 0 {
+			t.Fatalf("Minimize() = %q, lost the crashing marker", got)
+		}
+	})
+}